@@ -0,0 +1,72 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func writeEmptySchemas(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(`{"type":"object"}`), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCollectExtensionSchemaFilesFromDir(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptySchemas(t, dir, "x-foo.json", "x-bar.json", "not-an-extension.json")
+
+	got, err := collectExtensionSchemaFiles(dir)
+	if err != nil {
+		t.Fatalf("collectExtensionSchemaFiles: %v", err)
+	}
+	var names []string
+	for _, f := range got {
+		names = append(names, filepath.Base(f))
+	}
+	sort.Strings(names)
+	want := []string{"x-bar.json", "x-foo.json"}
+	if len(names) != len(want) || names[0] != want[0] || names[1] != want[1] {
+		t.Errorf("collectExtensionSchemaFiles(%s) = %v, want %v", dir, names, want)
+	}
+}
+
+func TestCollectExtensionSchemaFilesFromGlob(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptySchemas(t, dir, "x-foo.json", "x-bar.json")
+
+	got, err := collectExtensionSchemaFiles(filepath.Join(dir, "x-*.json"))
+	if err != nil {
+		t.Fatalf("collectExtensionSchemaFiles: %v", err)
+	}
+	if len(got) != 2 {
+		t.Errorf("collectExtensionSchemaFiles(glob) returned %d files, want 2", len(got))
+	}
+}
+
+func TestCollectExtensionSchemaFilesNoneFound(t *testing.T) {
+	dir := t.TempDir()
+	writeEmptySchemas(t, dir, "not-an-extension.json")
+
+	if _, err := collectExtensionSchemaFiles(dir); err == nil {
+		t.Error("expected an error when no x-*.json files are present")
+	}
+}
@@ -0,0 +1,332 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/googleapis/gnostic/jsonschema"
+)
+
+func TestDetectOpenAPIDialectDefaultsToV2(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "x-foo.json")
+	contents := `{"id": "x-foo", "type": "object", "properties": {"bar": {"type": "string"}}}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dialect, err := detectOpenAPIDialect(schemaFile)
+	if err != nil {
+		t.Fatalf("detectOpenAPIDialect: %v", err)
+	}
+	if dialect != "v2" {
+		t.Errorf("dialect = %q, want v2 for a schema with no v2/v3 signal", dialect)
+	}
+}
+
+func TestDetectOpenAPIDialectExplicitVersion(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "doc.json")
+	contents := `{"openapi": "3.0.0", "info": {"title": "t", "version": "1"}}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dialect, err := detectOpenAPIDialect(schemaFile)
+	if err != nil {
+		t.Fatalf("detectOpenAPIDialect: %v", err)
+	}
+	if dialect != "v3" {
+		t.Errorf("dialect = %q, want v3 for an explicit openapi:3 document", dialect)
+	}
+}
+
+func TestDetectOpenAPIDialectSwaggerIsV2(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "doc.json")
+	contents := `{"swagger": "2.0", "info": {"title": "t", "version": "1"}}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dialect, err := detectOpenAPIDialect(schemaFile)
+	if err != nil {
+		t.Fatalf("detectOpenAPIDialect: %v", err)
+	}
+	if dialect != "v2" {
+		t.Errorf("dialect = %q, want v2 for a swagger:2.0 document", dialect)
+	}
+}
+
+// TestDetectOpenAPIDialectV3Fixtures covers vendor-extension schemas that
+// carry no "openapi"/"swagger" keyword at all (the common case, since they
+// describe only an "x-*" fragment) but use v3-only JSON Schema constructs,
+// which should still be enough to detect v3.
+func TestDetectOpenAPIDialectV3Fixtures(t *testing.T) {
+	fixtures := []string{
+		"../extensions/x-ms-enum.json",
+		"../extensions/x-amazon-apigateway-integration.json",
+	}
+	for _, fixture := range fixtures {
+		dialect, err := detectOpenAPIDialect(fixture)
+		if err != nil {
+			t.Fatalf("detectOpenAPIDialect(%s): %v", fixture, err)
+		}
+		if dialect != "v3" {
+			t.Errorf("detectOpenAPIDialect(%s) = %q, want v3", fixture, dialect)
+		}
+	}
+}
+
+// TestGenerateExtensionRejectsV3Fixtures checks that the v3-construct
+// fixtures used above are rejected by GenerateExtension with the
+// UnsupportedV3ConstructError message, rather than silently mis-generating or
+// failing with some other unrelated error further down the pipeline. Same
+// caveat as TestGenerateExtensionRoundTrip: this needs the full gnostic
+// module (jsonschema/compiler packages, the domain model) to actually run,
+// which this standalone chunk's sandbox doesn't have.
+func TestGenerateExtensionRejectsV3Fixtures(t *testing.T) {
+	fixtures := []string{
+		"../extensions/x-ms-enum.json",
+		"../extensions/x-amazon-apigateway-integration.json",
+	}
+	for _, fixture := range fixtures {
+		err := GenerateExtension(fixture, t.TempDir(), GeneratorOptions{})
+		if err == nil {
+			t.Errorf("GenerateExtension(%s): expected an error rejecting its v3-only constructs, got nil", fixture)
+		} else if !strings.Contains(err.Error(), "OpenAPI v3-only construct") {
+			t.Errorf("GenerateExtension(%s): expected an UnsupportedV3ConstructError, got: %v", fixture, err)
+		}
+	}
+}
+
+// TestUnsupportedV3ConstructError checks that the message of
+// UnsupportedV3ConstructError, the exported error type GenerateExtensions'
+// rejection path uses for v3-only constructs, names the schema file it was
+// raised for.
+func TestUnsupportedV3ConstructError(t *testing.T) {
+	err := &UnsupportedV3ConstructError{SchemaFile: "x-example.json"}
+	if !strings.Contains(err.Error(), "x-example.json") {
+		t.Errorf("UnsupportedV3ConstructError.Error() = %q, want it to name the schema file", err.Error())
+	}
+}
+
+func TestResolveOpenAPIDialectExplicitFlagWins(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "doc.json")
+	contents := `{"openapi": "3.0.0"}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dialect, err := resolveOpenAPIDialect(schemaFile, "v2")
+	if err != nil {
+		t.Fatalf("resolveOpenAPIDialect: %v", err)
+	}
+	if dialect != "v2" {
+		t.Errorf("dialect = %q, want v2 when --openapi_version=v2 is explicit, even over a v3 document", dialect)
+	}
+}
+
+func TestAnnotateGogoObjectFields(t *testing.T) {
+	protoContent := "message Foo {\n" +
+		"  string foo_bar = 1;\n" +
+		"  string untouched = 2;\n" +
+		"}\n" +
+		"message Bar {\n" +
+		"  string baz = 1;\n" +
+		"}\n"
+	fieldsByMessage := map[string]map[string]fieldAnnotation{
+		"Foo": {
+			"foo_bar": fieldAnnotation{forceNotNullable: true, customName: "FooBar"},
+		},
+		"Bar": {
+			"baz": fieldAnnotation{forceNotNullable: true},
+		},
+	}
+	got := annotateGogoObjectFields(protoContent, fieldsByMessage)
+
+	for _, want := range []string{
+		`string foo_bar = 1 [(gogoproto.nullable) = false, (gogoproto.customname) = "FooBar"];`,
+		`string baz = 1 [(gogoproto.nullable) = false];`,
+		"  string untouched = 2;\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("annotateGogoObjectFields output missing %q, got:\n%s", want, got)
+		}
+	}
+}
+
+// TestGogoObjectFieldAnnotations exercises gogoObjectFieldAnnotations
+// itself, not just annotateGogoObjectFields's handling of hand-built
+// annotations: "fooBar" is required and already round-trips through
+// toSnake/toCamel/lowerFirst, so it should get forceNotNullable but no
+// customname; "URL" is an acronym that doesn't round-trip ("URL" ->
+// "u_r_l" -> "URL" -> lowerFirst "uRL" != "URL"), so it should get a
+// customname restoring the original spelling.
+func TestGogoObjectFieldAnnotations(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "x-test.json")
+	contents := `{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"id": "x-test",
+		"definitions": {
+			"Foo": {
+				"id": "x-foo",
+				"type": "object",
+				"properties": {
+					"fooBar": {"type": "string"},
+					"URL": {"type": "string"}
+				},
+				"required": ["fooBar"]
+			}
+		}
+	}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	schema, err := jsonschema.NewSchemaFromFile(schemaFile)
+	if err != nil {
+		t.Fatalf("NewSchemaFromFile: %v", err)
+	}
+	var definition *jsonschema.Schema
+	for _, pair := range *(schema.Definitions) {
+		if pair.Name == "Foo" {
+			definition = pair.Value
+		}
+	}
+	if definition == nil {
+		t.Fatal(`definition "Foo" not found`)
+	}
+
+	annotations := gogoObjectFieldAnnotations(definition)
+
+	fooBar, ok := annotations["foo_bar"]
+	if !ok || !fooBar.forceNotNullable {
+		t.Errorf("foo_bar: want forceNotNullable=true (it's required), got %+v, ok=%v", fooBar, ok)
+	}
+	if fooBar.customName != "" {
+		t.Errorf("foo_bar: want no customname override (it already round-trips), got %q", fooBar.customName)
+	}
+
+	url, ok := annotations["u_r_l"]
+	if !ok || url.customName != "URL" {
+		t.Errorf(`u_r_l: want customname="URL" (it doesn't round-trip), got %+v, ok=%v`, url, ok)
+	}
+}
+
+func TestResolveOpenAPIDialectRejectsUnknownVersion(t *testing.T) {
+	if _, err := resolveOpenAPIDialect("unused.json", "v4"); err == nil {
+		t.Error("expected an error for an unrecognized --openapi_version")
+	}
+}
+
+// TestGenerateExtensionRoundTrip generates an object extension and a
+// primitive extension from one schema file and checks that main.go was
+// written with the expected dispatch code; the per-schema proto/.go pair is
+// not inspected.
+// Like the rest of this package, GenerateExtension depends on the
+// jsonschema/compiler packages and the domain model (NewDomain,
+// BuildTypeForDefinition, GenerateProto, GenerateCompiler) living in the
+// full github.com/googleapis/gnostic module, plus `go list -m` resolving
+// that module to locate jsonschema/schema.json; none of that is present in
+// this standalone chunk's sandbox, so this test cannot run here (there's no
+// Go toolchain or go.mod at all in this tree) but documents, and will
+// exercise, the round trip the dialect-detection work sits on top of once
+// run inside a real gnostic module checkout.
+func TestGenerateExtensionRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	schemaFile := filepath.Join(dir, "x-test.json")
+	contents := `{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"id": "x-test",
+		"definitions": {
+			"Foo": {
+				"id": "x-foo",
+				"type": "object",
+				"properties": {"bar": {"type": "string"}}
+			},
+			"FooCount": {
+				"id": "x-foo-count",
+				"type": "integer"
+			}
+		}
+	}`
+	if err := ioutil.WriteFile(schemaFile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+
+	if err := GenerateExtension(schemaFile, outDir, GeneratorOptions{}); err != nil {
+		t.Fatalf("GenerateExtension: %v", err)
+	}
+
+	mainSource, err := ioutil.ReadFile(filepath.Join(outDir, "openapi_extensions_test", "main.go"))
+	if err != nil {
+		t.Fatalf("reading generated main.go: %v", err)
+	}
+	for _, want := range []string{`case "x-foo":`, `case "x-foo-count":`, "NewFoo("} {
+		if !strings.Contains(string(mainSource), want) {
+			t.Errorf("generated main.go missing %q:\n%s", want, mainSource)
+		}
+	}
+}
+
+// TestGenerateExtensionsRejectsDuplicateMessageName is the batch-collision
+// counterpart to TestGenerateExtensionRoundTrip: two schema files that
+// define distinct extension IDs but reuse the same message/type name (both
+// written into the same proto/Go package) must be rejected up front rather
+// than left to fail as a confusing duplicate-symbol `go build` error. Same
+// caveat as TestGenerateExtensionRoundTrip applies: this needs the full
+// gnostic module (jsonschema/compiler packages, the domain model) to
+// actually run, which this standalone chunk's sandbox doesn't have.
+func TestGenerateExtensionsRejectsDuplicateMessageName(t *testing.T) {
+	dir := t.TempDir()
+	schemaA := filepath.Join(dir, "x-foo.json")
+	schemaB := filepath.Join(dir, "x-bar.json")
+	// Both files define a definition named "Shared" with different
+	// extension ids, so the extension-id collision check alone wouldn't
+	// catch the resulting duplicate "Shared" message/type in the shared
+	// output package.
+	contentsA := `{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"id": "x-foo",
+		"definitions": {
+			"Shared": {"id": "x-foo", "type": "object", "properties": {"a": {"type": "string"}}}
+		}
+	}`
+	contentsB := `{
+		"$schema": "http://json-schema.org/draft-04/schema#",
+		"id": "x-bar",
+		"definitions": {
+			"Shared": {"id": "x-bar", "type": "object", "properties": {"b": {"type": "string"}}}
+		}
+	}`
+	if err := ioutil.WriteFile(schemaA, []byte(contentsA), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(schemaB, []byte(contentsB), 0644); err != nil {
+		t.Fatal(err)
+	}
+	outDir := t.TempDir()
+
+	err := GenerateExtensions([]string{schemaA, schemaB}, outDir, GeneratorOptions{})
+	if err == nil {
+		t.Fatal("expected an error for a message/type name reused across schema files")
+	}
+	if !strings.Contains(err.Error(), "Shared") {
+		t.Errorf("expected the error to name the colliding message/type, got: %v", err)
+	}
+}
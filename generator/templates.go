@@ -0,0 +1,231 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are made available to every template rendered by this
+// generator, so that template authors don't need to reimplement name
+// munging that toProtoPackageName already does.
+var templateFuncs = template.FuncMap{
+	"toCamel":    toCamel,
+	"toSnake":    toSnake,
+	"lowerFirst": lowerFirst,
+}
+
+// toCamel converts a snake_case or kebab-case identifier to UpperCamelCase.
+func toCamel(input string) string {
+	var out strings.Builder
+	upperNext := true
+	for _, r := range input {
+		if r == '_' || r == '-' {
+			upperNext = true
+			continue
+		}
+		if upperNext {
+			out.WriteRune(toUpperRune(r))
+			upperNext = false
+		} else {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// toSnake converts a CamelCase identifier to snake_case.
+func toSnake(input string) string {
+	var out strings.Builder
+	for index, r := range input {
+		if r >= 'A' && r <= 'Z' {
+			if index > 0 {
+				out.WriteRune('_')
+			}
+			out.WriteRune(r - 'A' + 'a')
+		} else {
+			out.WriteRune(r)
+		}
+	}
+	return out.String()
+}
+
+// lowerFirst lower-cases the first rune of input, leaving the rest untouched.
+func lowerFirst(input string) string {
+	if input == "" {
+		return input
+	}
+	r := []rune(input)
+	r[0] = toLowerRune(r[0])
+	return string(r)
+}
+
+func toUpperRune(r rune) rune {
+	if r >= 'a' && r <= 'z' {
+		return r - 'a' + 'A'
+	}
+	return r
+}
+
+func toLowerRune(r rune) rune {
+	if r >= 'A' && r <= 'Z' {
+		return r - 'A' + 'a'
+	}
+	return r
+}
+
+// extensionTemplateInfo describes a single extension type for consumption by
+// main.go.tmpl. A sorted slice of these is passed to the template so that
+// generated output is deterministic.
+type extensionTemplateInfo struct {
+	ExtensionName    string
+	SchemaName       string
+	IsPrimitive      bool
+	GoType           string
+	WrapperProtoName string
+}
+
+// mainTemplateContext is the data made available to main.go.tmpl.
+type mainTemplateContext struct {
+	PackageName   string // the "main" package name for the generated binary
+	GoPackageName string // the package name of the generated compiler code
+	License       string
+	Imports       []string
+	Extensions    []extensionTemplateInfo
+	// Gogo is true when generating for --gogo mode, in which case
+	// primitive extensions are boxed in a github.com/gogo/protobuf/types
+	// wrapper instead of a github.com/golang/protobuf/ptypes/wrappers one,
+	// matching the gogo/protobuf proto.Message implementation used
+	// elsewhere in the generated file.
+	Gogo bool
+}
+
+// wrapperTemplateContext is the data made available to compiler.go.tmpl and
+// extension.proto.tmpl, which wrap already-generated compiler/proto content
+// produced by the domain model.
+type wrapperTemplateContext struct {
+	PackageName string
+	License     string
+	Imports     []string
+	Content     string
+}
+
+const defaultMainGoTemplateText = `{{.License}}// THIS FILE IS AUTOMATICALLY GENERATED.
+
+package {{.PackageName}}
+
+import (
+{{range .Imports}}	"{{.}}"
+{{end}})
+
+func handleExtension(extensionName string, yamlInput string) (bool, proto.Message, error) {
+	switch extensionName {
+	// All supported extensions
+{{range .Extensions}}{{if .IsPrimitive}}
+	case "{{.ExtensionName}}":
+		var info {{.GoType}}
+		err := yaml.Unmarshal([]byte(yamlInput), &info)
+		if err != nil {
+			return true, nil, err
+		}
+{{if $.Gogo}}		newObject := &types.{{.WrapperProtoName}}{Value: info}
+{{else}}		newObject := &wrappers.{{.WrapperProtoName}}{Value: info}
+{{end}}		return true, newObject, nil
+{{else}}
+	case "{{.ExtensionName}}":
+		var info yaml.MapSlice
+		err := yaml.Unmarshal([]byte(yamlInput), &info)
+		if err != nil {
+			return true, nil, err
+		}
+		newObject, err := {{$.GoPackageName}}.New{{.SchemaName}}(info, compiler.NewContext("$root", nil))
+		return true, newObject, err
+{{end}}{{end}}
+	default:
+		return false, nil, nil
+	}
+}
+
+func main() {
+	openapiextension_v1.ProcessExtension(handleExtension)
+}
+`
+
+const defaultCompilerGoTemplateText = `{{.Content}}`
+
+const defaultExtensionProtoTemplateText = `{{.Content}}`
+
+// templateSet holds the parsed templates used to render the generated
+// main.go, compiler.go, and extension.proto files. Callers may override any
+// subset of these via --template_dir; the rest fall back to the defaults
+// shipped here.
+type templateSet struct {
+	main     *template.Template
+	compiler *template.Template
+	proto    *template.Template
+}
+
+// loadTemplateSet parses the default templates and, if templateDir is
+// non-empty, overlays main.go.tmpl, compiler.go.tmpl, and/or
+// extension.proto.tmpl found there on top of them.
+func loadTemplateSet(templateDir string) (*templateSet, error) {
+	main, err := template.New("main.go.tmpl").Funcs(templateFuncs).Parse(defaultMainGoTemplateText)
+	if err != nil {
+		return nil, err
+	}
+	compilerTmpl, err := template.New("compiler.go.tmpl").Funcs(templateFuncs).Parse(defaultCompilerGoTemplateText)
+	if err != nil {
+		return nil, err
+	}
+	protoTmpl, err := template.New("extension.proto.tmpl").Funcs(templateFuncs).Parse(defaultExtensionProtoTemplateText)
+	if err != nil {
+		return nil, err
+	}
+	set := &templateSet{main: main, compiler: compilerTmpl, proto: protoTmpl}
+
+	if templateDir == "" {
+		return set, nil
+	}
+
+	overrides := []struct {
+		filename string
+		dest     **template.Template
+	}{
+		{"main.go.tmpl", &set.main},
+		{"compiler.go.tmpl", &set.compiler},
+		{"extension.proto.tmpl", &set.proto},
+	}
+	for _, override := range overrides {
+		filePath := path.Join(templateDir, override.filename)
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("reading template %s: %v", filePath, err)
+		}
+		tmpl, err := template.New(override.filename).Funcs(templateFuncs).Parse(string(contents))
+		if err != nil {
+			return nil, fmt.Errorf("parsing template %s: %v", filePath, err)
+		}
+		*override.dest = tmpl
+	}
+	return set, nil
+}
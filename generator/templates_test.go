@@ -0,0 +1,140 @@
+// Copyright 2017 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToCamel(t *testing.T) {
+	cases := map[string]string{
+		"x-foo-bar":  "XFooBar",
+		"foo_bar":    "FooBar",
+		"alreadyOne": "AlreadyOne",
+	}
+	for input, want := range cases {
+		if got := toCamel(input); got != want {
+			t.Errorf("toCamel(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestToSnake(t *testing.T) {
+	cases := map[string]string{
+		"FooBar":  "foo_bar",
+		"fooBar":  "foo_bar",
+		"already": "already",
+	}
+	for input, want := range cases {
+		if got := toSnake(input); got != want {
+			t.Errorf("toSnake(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+func TestLowerFirst(t *testing.T) {
+	cases := map[string]string{
+		"Foo": "foo",
+		"":    "",
+		"f":   "f",
+	}
+	for input, want := range cases {
+		if got := lowerFirst(input); got != want {
+			t.Errorf("lowerFirst(%q) = %q, want %q", input, got, want)
+		}
+	}
+}
+
+// TestMainTemplateObjectExtension is a regression test for a bug where the
+// object-extension branch of main.go.tmpl referenced .GoPackageName instead
+// of $.GoPackageName: since the range over .Extensions rebinds dot to each
+// extensionTemplateInfo, which has no GoPackageName field, execution failed
+// for every object-type extension (the common case) with "can't evaluate
+// field GoPackageName in type extensionTemplateInfo".
+func TestMainTemplateObjectExtension(t *testing.T) {
+	templates, err := loadTemplateSet("")
+	if err != nil {
+		t.Fatalf("loadTemplateSet: %v", err)
+	}
+	ctx := mainTemplateContext{
+		PackageName:   "main",
+		GoPackageName: "foopkg",
+		License:       "// license\n",
+		Imports:       []string{"github.com/googleapis/gnostic/compiler"},
+		Extensions: []extensionTemplateInfo{
+			{ExtensionName: "x-foo", SchemaName: "Foo"},
+		},
+	}
+	var out strings.Builder
+	if err := templates.main.Execute(&out, ctx); err != nil {
+		t.Fatalf("executing main.go.tmpl for an object extension: %v", err)
+	}
+	if !strings.Contains(out.String(), "foopkg.NewFoo(") {
+		t.Errorf("expected dispatch to foopkg.NewFoo(...), got:\n%s", out.String())
+	}
+}
+
+func TestMainTemplatePrimitiveExtension(t *testing.T) {
+	templates, err := loadTemplateSet("")
+	if err != nil {
+		t.Fatalf("loadTemplateSet: %v", err)
+	}
+	ctx := mainTemplateContext{
+		PackageName:   "main",
+		GoPackageName: "foopkg",
+		License:       "// license\n",
+		Extensions: []extensionTemplateInfo{
+			{ExtensionName: "x-foo-count", SchemaName: "FooCount", IsPrimitive: true, GoType: "int64", WrapperProtoName: "Int64Value"},
+		},
+	}
+	var out strings.Builder
+	if err := templates.main.Execute(&out, ctx); err != nil {
+		t.Fatalf("executing main.go.tmpl for a primitive extension: %v", err)
+	}
+	if !strings.Contains(out.String(), "wrappers.Int64Value{Value: info}") {
+		t.Errorf("expected a wrappers.Int64Value box, got:\n%s", out.String())
+	}
+}
+
+// TestMainTemplateGogoPrimitiveExtension guards against --gogo output
+// referencing a per-schema message type that GenerateProto never actually
+// generates (proto generation isn't gogo-aware): gogo/protobuf/types ships
+// real drop-in wrapper messages, so that's what gogo mode should box into.
+func TestMainTemplateGogoPrimitiveExtension(t *testing.T) {
+	templates, err := loadTemplateSet("")
+	if err != nil {
+		t.Fatalf("loadTemplateSet: %v", err)
+	}
+	ctx := mainTemplateContext{
+		PackageName:   "main",
+		GoPackageName: "foopkg",
+		License:       "// license\n",
+		Gogo:          true,
+		Extensions: []extensionTemplateInfo{
+			{ExtensionName: "x-foo-count", SchemaName: "FooCount", IsPrimitive: true, GoType: "int64", WrapperProtoName: "Int64Value"},
+		},
+	}
+	var out strings.Builder
+	if err := templates.main.Execute(&out, ctx); err != nil {
+		t.Fatalf("executing main.go.tmpl for a gogo primitive extension: %v", err)
+	}
+	if !strings.Contains(out.String(), "types.Int64Value{Value: info}") {
+		t.Errorf("expected a gogo/protobuf/types.Int64Value box, got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "foopkg.FooCount{Value: info}") {
+		t.Errorf("gogo primitive box must not reference a per-schema message the proto generator never emits, got:\n%s", out.String())
+	}
+}